@@ -0,0 +1,289 @@
+// Package ca implements a minimal, self-contained internal certificate
+// authority. It is the automatic certificate source for hosts that have no
+// static CertFile/KeyFile and are not served by Let's Encrypt, such as
+// intranet hostnames that an ACME CA can never validate ownership of.
+package ca
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// KeyTypeECDSA generates ECDSA P-256 keys (the default).
+	KeyTypeECDSA = "ecdsa"
+	// KeyTypeRSA generates 2048-bit RSA keys.
+	KeyTypeRSA = "rsa"
+
+	rootCertFile = "root.crt"
+	rootKeyFile  = "root.key"
+
+	defaultValidity    = 30 * 24 * time.Hour
+	defaultRenewBefore = 24 * time.Hour
+)
+
+type (
+	// Config controls how the internal CA generates its root and issues
+	// leaf certificates.
+	Config struct {
+		// Dir is where the root cert/key are persisted. It is required:
+		// New returns an error when it is empty.
+		Dir string
+		// KeyType is KeyTypeECDSA (default) or KeyTypeRSA.
+		KeyType string
+		// Validity is how long issued leaf certificates are valid for.
+		// Defaults to 30 days.
+		Validity time.Duration
+		// RenewBefore is how long before expiry a cached leaf is
+		// re-issued. Defaults to 24 hours.
+		RenewBefore time.Duration
+	}
+
+	// CA is a self-signed root that lazily issues and caches leaf
+	// certificates for hosts it is asked about.
+	CA struct {
+		dir         string
+		keyType     string
+		validity    time.Duration
+		renewBefore time.Duration
+
+		rootCert *x509.Certificate
+		rootKey  interface{}
+		rootPEM  []byte
+
+		mutex sync.RWMutex
+		cache map[string]*tls.Certificate
+	}
+)
+
+// New loads the root certificate from cfg.Dir, generating and persisting a
+// new one on first use.
+func New(cfg Config) (*CA, error) {
+	if cfg.Dir == "" {
+		return nil, errors.New("ca: Dir is required")
+	}
+	if cfg.KeyType == "" {
+		cfg.KeyType = KeyTypeECDSA
+	}
+	if cfg.Validity == 0 {
+		cfg.Validity = defaultValidity
+	}
+	if cfg.RenewBefore == 0 {
+		cfg.RenewBefore = defaultRenewBefore
+	}
+
+	c := &CA{
+		dir:         cfg.Dir,
+		keyType:     cfg.KeyType,
+		validity:    cfg.Validity,
+		renewBefore: cfg.RenewBefore,
+		cache:       make(map[string]*tls.Certificate),
+	}
+
+	if err := os.MkdirAll(c.dir, 0700); err != nil {
+		return nil, err
+	}
+
+	if err := c.loadRoot(); err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err := c.generateRoot(); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+func (c *CA) loadRoot() error {
+	certPEM, err := ioutil.ReadFile(filepath.Join(c.dir, rootCertFile))
+	if err != nil {
+		return err
+	}
+	keyPEM, err := ioutil.ReadFile(filepath.Join(c.dir, rootKeyFile))
+	if err != nil {
+		return err
+	}
+
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	root, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return err
+	}
+
+	c.rootCert = root
+	c.rootKey = pair.PrivateKey
+	c.rootPEM = certPEM
+	return nil
+}
+
+func (c *CA) generateRoot() error {
+	key, err := c.generateKey()
+	if err != nil {
+		return err
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "armor internal CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, publicKey(key), key)
+	if err != nil {
+		return err
+	}
+	root, err := x509.ParseCertificate(der)
+	if err != nil {
+		return err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+
+	if err := ioutil.WriteFile(filepath.Join(c.dir, rootCertFile), certPEM, 0644); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(c.dir, rootKeyFile), keyPEM, 0600); err != nil {
+		return err
+	}
+
+	c.rootCert = root
+	c.rootKey = key
+	c.rootPEM = certPEM
+	return nil
+}
+
+func (c *CA) generateKey() (interface{}, error) {
+	if c.keyType == KeyTypeRSA {
+		return rsa.GenerateKey(rand.Reader, 2048)
+	}
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+// RootPEM returns the PEM-encoded root certificate, to be distributed to
+// clients as a trust anchor.
+func (c *CA) RootPEM() []byte {
+	return c.rootPEM
+}
+
+// GetCertificate returns a leaf certificate for the SNI name in hello,
+// issuing and caching one lazily, and re-issuing it once it is within
+// RenewBefore of expiry.
+func (c *CA) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	host := hello.ServerName
+	if host == "" {
+		return nil, errors.New("ca: missing SNI server name")
+	}
+
+	c.mutex.RLock()
+	cert, ok := c.cache[host]
+	c.mutex.RUnlock()
+	if ok && !needsRenewal(cert, c.renewBefore) {
+		return cert, nil
+	}
+
+	cert, err := c.issue(host)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	c.cache[host] = cert
+	c.mutex.Unlock()
+
+	return cert, nil
+}
+
+func needsRenewal(cert *tls.Certificate, renewBefore time.Duration) bool {
+	if cert.Leaf == nil {
+		return true
+	}
+	return time.Now().Add(renewBefore).After(cert.Leaf.NotAfter)
+}
+
+func (c *CA) issue(host string) (*tls.Certificate, error) {
+	key, err := c.generateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(c.validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, c.rootCert, publicKey(key), c.rootKey)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{der, c.rootCert.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+func randomSerial() (*big.Int, error) {
+	limit := new(big.Int).Lsh(big.NewInt(1), 128)
+	return rand.Int(rand.Reader, limit)
+}
+
+func publicKey(key interface{}) interface{} {
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		return &k.PublicKey
+	case *rsa.PrivateKey:
+		return &k.PublicKey
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,45 @@
+package ca
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewAndIssue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "armor-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := New(Config{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.RootPEM()) == 0 {
+		t.Fatal("expected a non-empty root PEM")
+	}
+	if _, err := os.Stat(filepath.Join(dir, rootCertFile)); err != nil {
+		t.Fatalf("root cert was not persisted: %v", err)
+	}
+
+	cert, err := c.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Leaf.Subject.CommonName != "example.com" {
+		t.Fatalf("expected leaf for example.com, got %s", cert.Leaf.Subject.CommonName)
+	}
+
+	// A second load from the same directory must reuse the persisted root.
+	reloaded, err := New(Config{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(reloaded.RootPEM()) != string(c.RootPEM()) {
+		t.Fatal("expected reloaded CA to reuse the persisted root")
+	}
+}
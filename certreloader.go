@@ -0,0 +1,285 @@
+package armor
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+var errInvalidClientCAFile = errors.New("armor: a client CA file or inline PEM does not contain a valid PEM certificate")
+
+type (
+	// certReloader keeps the certificates served over TLS, and the pool of
+	// client CAs trusted for mutual TLS, up to date with what is on disk. It
+	// rebuilds its snapshot whenever a watched file changes, on the periodic
+	// refresh interval, or on SIGHUP, so operators never have to restart
+	// Armor to pick up renewed certs or a rotated CA bundle.
+	certReloader struct {
+		armor *Armor
+
+		mutex      sync.RWMutex
+		nameToCert map[string]*tls.Certificate
+		clientCAs  *x509.CertPool
+
+		watcher  *fsnotify.Watcher
+		sigCh    chan os.Signal
+		interval time.Duration
+		done     chan struct{}
+	}
+)
+
+// newCertReloader loads the certificates and client CA bundle configured on
+// a, then starts watching them for changes.
+func newCertReloader(a *Armor) (*certReloader, error) {
+	cr := &certReloader{
+		armor:      a,
+		nameToCert: make(map[string]*tls.Certificate),
+		sigCh:      make(chan os.Signal, 1),
+		interval:   a.TLS.CertRefreshInterval,
+		done:       make(chan struct{}),
+	}
+	if cr.interval == 0 {
+		cr.interval = 10 * time.Minute
+	}
+
+	if err := cr.reload(); err != nil {
+		return nil, err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	cr.watcher = watcher
+	for _, dir := range cr.watchedDirs() {
+		if err := watcher.Add(dir); err != nil {
+			a.Logger.Warnf("certReloader: unable to watch %s: %v", dir, err)
+		}
+	}
+
+	signal.Notify(cr.sigCh, syscall.SIGHUP)
+
+	go cr.run()
+
+	return cr, nil
+}
+
+// watchedDirs returns the parent directories of every file the reloader
+// needs to watch. fsnotify watches directories, not files, so that it still
+// catches the atomic rename most editors and config-management tools use to
+// write a new cert in place.
+func (cr *certReloader) watchedDirs() []string {
+	a := cr.armor
+	dirs := map[string]struct{}{}
+	add := func(path string) {
+		if path == "" {
+			return
+		}
+		dirs[filepath.Dir(path)] = struct{}{}
+	}
+
+	add(a.TLS.CertFile)
+	add(a.TLS.KeyFile)
+	add(a.TLS.ClientCAFile)
+	for _, file := range a.TLS.ClientCAFiles {
+		add(file)
+	}
+	for _, host := range a.Hosts {
+		add(host.CertFile)
+		add(host.KeyFile)
+	}
+
+	out := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		out = append(out, dir)
+	}
+	return out
+}
+
+// reload rebuilds the certificate and client CA snapshot from disk and
+// atomically swaps it in.
+func (cr *certReloader) reload() error {
+	a := cr.armor
+	nameToCert := make(map[string]*tls.Certificate)
+
+	load := func(certFile, keyFile string) error {
+		if certFile == "" || keyFile == "" {
+			return nil
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return err
+		}
+		cert.Leaf = leaf
+		a.Logger.Infof("certReloader: loaded cert=%s fingerprint=%s", certFile, fingerprint(leaf))
+
+		if len(leaf.DNSNames) == 0 {
+			nameToCert[leaf.Subject.CommonName] = &cert
+		}
+		for _, name := range leaf.DNSNames {
+			nameToCert[name] = &cert
+		}
+		return nil
+	}
+
+	if err := load(a.TLS.CertFile, a.TLS.KeyFile); err != nil {
+		return err
+	}
+	for _, host := range a.Hosts {
+		if err := load(host.CertFile, host.KeyFile); err != nil {
+			return err
+		}
+	}
+
+	clientCAFiles := a.TLS.ClientCAFiles
+	if a.TLS.ClientCAFile != "" {
+		clientCAFiles = append([]string{a.TLS.ClientCAFile}, clientCAFiles...)
+	}
+
+	var clientCAs *x509.CertPool
+	if len(clientCAFiles) > 0 || len(a.TLS.ClientCAPEMs) > 0 {
+		pool := x509.NewCertPool()
+		for _, file := range clientCAFiles {
+			pem, err := ioutil.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return errInvalidClientCAFile
+			}
+			a.Logger.Infof("certReloader: reloaded client CA bundle %s", file)
+		}
+		for _, pem := range a.TLS.ClientCAPEMs {
+			if !pool.AppendCertsFromPEM([]byte(pem)) {
+				return errInvalidClientCAFile
+			}
+		}
+		clientCAs = pool
+	}
+
+	cr.mutex.Lock()
+	cr.nameToCert = nameToCert
+	cr.clientCAs = clientCAs
+	cr.mutex.Unlock()
+
+	return nil
+}
+
+// run watches for file-system events, the periodic refresh tick and SIGHUP,
+// reloading the snapshot on each.
+func (cr *certReloader) run() {
+	ticker := time.NewTicker(cr.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-cr.done:
+			return
+		case <-cr.sigCh:
+			cr.armor.Logger.Info("certReloader: SIGHUP received, reloading certificates")
+			if err := cr.reload(); err != nil {
+				cr.armor.Logger.Errorf("certReloader: reload failed: %v", err)
+			}
+		case <-ticker.C:
+			if err := cr.reload(); err != nil {
+				cr.armor.Logger.Errorf("certReloader: reload failed: %v", err)
+			}
+		case event, ok := <-cr.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+				continue
+			}
+			if err := cr.reload(); err != nil {
+				cr.armor.Logger.Errorf("certReloader: reload after %s failed: %v", event.Name, err)
+			}
+		case err, ok := <-cr.watcher.Errors:
+			if !ok {
+				return
+			}
+			cr.armor.Logger.Errorf("certReloader: watcher error: %v", err)
+		}
+	}
+}
+
+// Close stops the watcher goroutine.
+func (cr *certReloader) Close() error {
+	close(cr.done)
+	return cr.watcher.Close()
+}
+
+// GetCertificate looks up the certificate matching the client's requested
+// SNI name against the current snapshot.
+func (cr *certReloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, bool) {
+	cr.mutex.RLock()
+	defer cr.mutex.RUnlock()
+	cert, ok := cr.nameToCert[hello.ServerName]
+	return cert, ok
+}
+
+// GetClientCAs returns the pool of client CAs currently trusted for mutual
+// TLS, or nil if none is configured.
+func (cr *certReloader) GetClientCAs() *x509.CertPool {
+	cr.mutex.RLock()
+	defer cr.mutex.RUnlock()
+	return cr.clientCAs
+}
+
+// GetConfigForClient is installed as tls.Config.GetConfigForClient so every
+// handshake clones the base TLS config and sets ClientCAs to whatever the
+// reloader's snapshot currently holds. This is what lets the client CA pool
+// rotate live: crypto/tls only verifies client certificates against the
+// ClientCAs in the *returned* config, so there is no static pool baked into
+// the listener at startup. The standard library performs the actual
+// verification (and populates ConnectionState.VerifiedChains) whenever mode
+// is VerifyClientCertIfGiven or RequireAndVerifyClientCert.
+func (cr *certReloader) GetConfigForClient(base *tls.Config, mode tls.ClientAuthType) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cfg := base.Clone()
+		cfg.ClientAuth = mode
+		cfg.ClientCAs = cr.GetClientCAs()
+		return cfg, nil
+	}
+}
+
+// clientAuthType maps the TLS.ClientAuthType config value to the matching
+// tls.ClientAuthType, so "verify-if-given" and "require-and-verify" actually
+// verify the presented chain (and populate VerifiedChains) rather than only
+// requiring that some certificate was sent.
+func clientAuthType(mode string) tls.ClientAuthType {
+	switch mode {
+	case "require":
+		return tls.RequireAnyClientCert
+	case "verify-if-given":
+		return tls.VerifyClientCertIfGiven
+	case "require-and-verify":
+		return tls.RequireAndVerifyClientCert
+	case "request":
+		fallthrough
+	default:
+		return tls.RequestClientCert
+	}
+}
+
+// fingerprint returns the hex-encoded SHA-256 fingerprint of cert, for
+// logging which certificate was just (re)loaded.
+func fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
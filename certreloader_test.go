@@ -0,0 +1,186 @@
+package armor
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/labstack/gommon/log"
+)
+
+// TestGetConfigForClientVerifiesChain drives a real TLS handshake through
+// certReloader.GetConfigForClient to make sure a client certificate signed
+// by the configured CA ends up in ConnectionState.VerifiedChains, which is
+// what plugin/mtls.go and the "cert:*" expression tags rely on.
+func TestGetConfigForClientVerifiesChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "armor-certreloader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	caCert, caKey := generateSelfSignedCA(t)
+	caPool := x509.NewCertPool()
+	caPool.AddCert(caCert)
+
+	serverCert, serverKey := issueLeaf(t, caCert, caKey, "localhost", false)
+	clientCert, clientKey := issueLeaf(t, caCert, caKey, "client", true)
+
+	writePEM(t, filepath.Join(dir, "server.crt"), "CERTIFICATE", serverCert.Raw)
+	writeKeyPEM(t, filepath.Join(dir, "server.key"), serverKey)
+	writePEM(t, filepath.Join(dir, "ca.crt"), "CERTIFICATE", caCert.Raw)
+
+	a := &Armor{
+		Logger: log.New("test"),
+		Hosts:  map[string]*Host{},
+		TLS: &TLS{
+			CertFile:     filepath.Join(dir, "server.crt"),
+			KeyFile:      filepath.Join(dir, "server.key"),
+			ClientCAFile: filepath.Join(dir, "ca.crt"),
+		},
+	}
+
+	reloader, err := newCertReloader(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reloader.Close()
+
+	serverTLSConfig := &tls.Config{}
+	serverTLSConfig.GetConfigForClient = reloader.GetConfigForClient(serverTLSConfig, tls.RequireAndVerifyClientCert)
+	serverTLSConfig.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert, _ := reloader.GetCertificate(hello)
+		return cert, nil
+	}
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", serverTLSConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	verified := make(chan bool, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			verified <- false
+			return
+		}
+		defer conn.Close()
+		tlsConn := conn.(*tls.Conn)
+		if err := tlsConn.Handshake(); err != nil {
+			verified <- false
+			return
+		}
+		verified <- len(tlsConn.ConnectionState().VerifiedChains) > 0
+	}()
+
+	clientTLSConfig := &tls.Config{
+		ServerName:   "localhost",
+		RootCAs:      caPool,
+		Certificates: []tls.Certificate{{Certificate: [][]byte{clientCert.Raw}, PrivateKey: clientKey}},
+	}
+	conn, err := tls.Dial("tcp", ln.Addr().String(), clientTLSConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if !<-verified {
+		t.Fatal("expected the server to populate VerifiedChains for a client certificate signed by the configured CA")
+	}
+}
+
+func generateSelfSignedCA(t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func issueLeaf(t *testing.T, caCert *x509.Certificate, caKey *ecdsa.PrivateKey, name string, clientAuth bool) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	if clientAuth {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	} else {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		template.DNSNames = []string{name}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := pem.Encode(f, &pem.Block{Type: blockType, Bytes: der}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeKeyPEM(t *testing.T, path string, key *ecdsa.PrivateKey) {
+	t.Helper()
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := pem.Encode(f, &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/labstack/armor/ca"
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/cobra"
+)
+
+var caCmd = &cobra.Command{
+	Use:   "ca",
+	Short: "Manage Armor's internal CA",
+}
+
+var caRootCacheDir string
+
+var caRootCmd = &cobra.Command{
+	Use:   "root",
+	Short: "Print the internal CA's root certificate as PEM",
+	Run: func(cmd *cobra.Command, args []string) {
+		dir := caRootCacheDir
+		if dir == "" {
+			var err error
+			dir, err = caCacheDir()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+		c, err := ca.New(ca.Config{Dir: dir})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Print(string(c.RootPEM()))
+	},
+}
+
+// caCacheDir mirrors the default TLS.InternalCA.CacheDir used by HTTP.StartTLS.
+// It is only a fallback: pass --cache-dir whenever the running server's
+// config sets TLS.InternalCA.CacheDir to something else, or this command
+// prints an unrelated root certificate.
+func caCacheDir() (string, error) {
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".armor", "ca"), nil
+}
+
+func init() {
+	caRootCmd.Flags().StringVar(&caRootCacheDir, "cache-dir", "", "internal CA cache dir (defaults to ~/.armor/ca; must match TLS.InternalCA.CacheDir if the server config overrides it)")
+	caCmd.AddCommand(caRootCmd)
+	rootCmd.AddCommand(caCmd)
+}
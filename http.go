@@ -1,6 +1,7 @@
 package armor
 
 import (
+	"context"
 	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
@@ -11,6 +12,8 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/labstack/armor/ca"
+	"github.com/labstack/armor/internal/domain"
 	"github.com/labstack/armor/util"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/gommon/log"
@@ -23,9 +26,11 @@ import (
 
 type (
 	HTTP struct {
-		armor  *Armor
-		echo   *echo.Echo
-		logger *log.Logger
+		armor      *Armor
+		echo       *echo.Echo
+		logger     *log.Logger
+		internalCA *ca.CA
+		domains    *domain.Map
 	}
 )
 
@@ -115,15 +120,40 @@ func (h *HTTP) StartTLS() error {
 	// Enable HTTP/2
 	s.TLSConfig.NextProtos = append(s.TLSConfig.NextProtos, "h2")
 
+	domainHosts := make(map[string]*domain.Host, len(a.Hosts))
+	for name, host := range a.Hosts {
+		domainHosts[name] = &domain.Host{Name: name, Value: host}
+	}
+	var defaultHost *domain.Host
+	if d, ok := a.Hosts[a.TLS.DefaultHost]; ok {
+		defaultHost = &domain.Host{Name: a.TLS.DefaultHost, Value: d}
+	}
+	domains, err := domain.NewMap(domainHosts, defaultHost)
+	if err != nil {
+		return err
+	}
+	h.domains = domains
+
 	if a.TLS.Auto {
-		// Enable the "http-01" challenge
-		e.Server.Handler = e.AutoTLSManager.HTTPHandler(e.Server.Handler)
+		if a.TLS.ChallengeType == "tls-alpn-01" {
+			// Enable the "tls-alpn-01" challenge; autocert.Manager.GetCertificate
+			// answers it directly once "acme-tls/1" is offered, so the HTTP
+			// handler does not need to be wrapped.
+			s.TLSConfig.NextProtos = append(s.TLSConfig.NextProtos, "acme-tls/1")
+		} else {
+			// Enable the "http-01" challenge
+			e.Server.Handler = e.AutoTLSManager.HTTPHandler(e.Server.Handler)
+		}
 
-		hosts := []string{}
-		for host := range a.Hosts {
-			hosts = append(hosts, host)
+		// HostPolicy now consults the domain map, so wildcard hosts (e.g.
+		// "*.example.com") are accepted instead of only the exact names in
+		// a.Hosts.
+		e.AutoTLSManager.HostPolicy = func(ctx context.Context, host string) error {
+			if !h.domains.Known(host) {
+				return fmt.Errorf("armor: host %q is not configured", host)
+			}
+			return nil
 		}
-		e.AutoTLSManager.HostPolicy = autocert.HostWhitelist(hosts...) // Added security
 		home, err := homedir.Dir()
 		if err != nil {
 			return err
@@ -132,67 +162,102 @@ func (h *HTTP) StartTLS() error {
 			a.TLS.CacheDir = filepath.Join(home, ".armor", "cache")
 		}
 		e.AutoTLSManager.Cache = autocert.DirCache(a.TLS.CacheDir)
+
+		if a.TLS.EABKeyID != "" && a.TLS.EABHMACKey != "" {
+			hmacKey, err := base64.RawURLEncoding.DecodeString(a.TLS.EABHMACKey)
+			if err != nil {
+				return err
+			}
+			e.AutoTLSManager.ExternalAccountBinding = &acme.ExternalAccountBinding{
+				KID: a.TLS.EABKeyID,
+				Key: hmacKey,
+			}
+		}
+		if a.TLS.PreferredChain != "" {
+			e.AutoTLSManager.Client.PreferredChain = a.TLS.PreferredChain
+		}
 	}
 
 	// Load certificates - start
-	// Global
-	if a.TLS.CertFile != "" && a.TLS.KeyFile != "" {
-		cert, err := tls.LoadX509KeyPair(a.TLS.CertFile, a.TLS.KeyFile)
-		if err != nil {
-			h.logger.Fatal(err)
-		}
-		s.TLSConfig.Certificates = append(s.TLSConfig.Certificates, cert)
+	reloader, err := newCertReloader(a)
+	if err != nil {
+		return err
 	}
-	// Host
-	for _, host := range a.Hosts {
-		if host.CertFile == "" || host.KeyFile == "" {
-			continue
+	if a.TLS.ClientCAFile != "" || len(a.TLS.ClientCAFiles) > 0 || len(a.TLS.ClientCAPEMs) > 0 {
+		s.TLSConfig.GetConfigForClient = reloader.GetConfigForClient(s.TLSConfig, clientAuthType(a.TLS.ClientAuthType))
+	}
+	// Load certificates - end
+
+	if a.TLS.InternalCA != nil && a.TLS.InternalCA.Enable {
+		dir := a.TLS.InternalCA.CacheDir
+		if dir == "" {
+			home, err := homedir.Dir()
+			if err != nil {
+				return err
+			}
+			dir = filepath.Join(home, ".armor", "ca")
 		}
-		cert, err := tls.LoadX509KeyPair(host.CertFile, host.KeyFile)
+		internalCA, err := ca.New(ca.Config{
+			Dir:      dir,
+			KeyType:  a.TLS.InternalCA.KeyType,
+			Validity: a.TLS.InternalCA.Validity,
+		})
 		if err != nil {
-			h.logger.Fatal(err)
+			return err
 		}
-		s.TLSConfig.Certificates = append(s.TLSConfig.Certificates, cert)
+		h.internalCA = internalCA
 	}
-	s.TLSConfig.BuildNameToCertificate()
-	// Load certificates - end
 
 	s.TLSConfig.GetCertificate = func(clientHello *tls.ClientHelloInfo) (*tls.Certificate, error) {
-		if cert, ok := s.TLSConfig.NameToCertificate[clientHello.ServerName]; ok {
+		if cert, ok := reloader.GetCertificate(clientHello); ok {
 			// Use provided certificate
 			return cert, nil
-		} else if a.TLS.Auto {
+		}
+
+		// ACME and the internal CA are tried in sequence rather than as
+		// mutually-exclusive branches, so a single Armor instance can serve
+		// public hosts via Let's Encrypt and intranet hosts via the internal
+		// CA side by side. A configured host ACME can't issue for
+		// (rate-limited, directory unreachable, ...) simply falls through to
+		// the internal CA instead of aborting the handshake; a host the
+		// domain map does not know gets neither.
+		if a.TLS.Auto {
 			cert, err := e.AutoTLSManager.GetCertificate(clientHello)
 			if err != nil {
-				return nil, err
-			}
-
-			if a.TLS.KeyPinning {
-				hostPins := h.armor.TLS.pins.pins[clientHello.ServerName]
-				if hostPins == nil {
-					hostPins = new(pins)
-					hostPins.m = make(map[string]struct{})
-				}
-
-				for _, crtDer := range cert.Certificate {
-					parsedCert, err := x509.ParseCertificate(crtDer)
-					if err != nil {
-						return nil, err
+				h.logger.Warnf("armor: acme certificate for %s unavailable, falling back: %v", clientHello.ServerName, err)
+			} else {
+				if a.TLS.KeyPinning {
+					hostPins := h.armor.TLS.pins.pins[clientHello.ServerName]
+					if hostPins == nil {
+						hostPins = new(pins)
+						hostPins.m = make(map[string]struct{})
 					}
-					pubKeyDer, err := x509.MarshalPKIXPublicKey(parsedCert.PublicKey)
-					if err != nil {
-						return nil, err
+
+					for _, crtDer := range cert.Certificate {
+						parsedCert, err := x509.ParseCertificate(crtDer)
+						if err != nil {
+							return nil, err
+						}
+						pubKeyDer, err := x509.MarshalPKIXPublicKey(parsedCert.PublicKey)
+						if err != nil {
+							return nil, err
+						}
+						hash := sha256.Sum256(pubKeyDer)
+						keyHashBase := base64.StdEncoding.EncodeToString(hash[:])
+						hostPins.m[keyHashBase] = struct{}{}
 					}
-					hash := sha256.Sum256(pubKeyDer)
-					keyHashBase := base64.StdEncoding.EncodeToString(hash[:])
-					hostPins.m[keyHashBase] = struct{}{}
+					h.armor.TLS.pins.mutex.Lock()
+					defer h.armor.TLS.pins.mutex.Unlock()
+					h.armor.TLS.pins.pins[clientHello.ServerName] = hostPins
 				}
-				h.armor.TLS.pins.mutex.Lock()
-				defer h.armor.TLS.pins.mutex.Unlock()
-				h.armor.TLS.pins.pins[clientHello.ServerName] = hostPins
+				return cert, nil
 			}
-			return cert, err
 		}
+
+		if h.internalCA != nil && h.domains.Known(clientHello.ServerName) {
+			return h.internalCA.GetCertificate(clientHello)
+		}
+
 		return nil, nil // No certificate
 	}
 
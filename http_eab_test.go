@@ -0,0 +1,51 @@
+// +build integration
+
+package armor
+
+import (
+	"crypto/tls"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestStartTLSEAB exercises the ACME EAB flow against a local pebble or
+// step-ca instance. It only runs when ARMOR_ACME_DIRECTORY_URL and
+// ARMOR_ACME_EAB_KEY_ID/ARMOR_ACME_EAB_HMAC_KEY point at a running server,
+// since it is not something CI can do without one.
+func TestStartTLSEAB(t *testing.T) {
+	directoryURL := os.Getenv("ARMOR_ACME_DIRECTORY_URL")
+	eabKeyID := os.Getenv("ARMOR_ACME_EAB_KEY_ID")
+	eabHMACKey := os.Getenv("ARMOR_ACME_EAB_HMAC_KEY")
+	if directoryURL == "" || eabKeyID == "" || eabHMACKey == "" {
+		t.Skip("ARMOR_ACME_DIRECTORY_URL, ARMOR_ACME_EAB_KEY_ID and ARMOR_ACME_EAB_HMAC_KEY must be set")
+	}
+
+	a := New()
+	a.Hosts = map[string]*Host{"armor.test": {}}
+	a.TLS = &TLS{
+		Address:      ":0",
+		Auto:         true,
+		DirectoryURL: directoryURL,
+		EABKeyID:     eabKeyID,
+		EABHMACKey:   eabHMACKey,
+		Email:        "armor-test@labstack.com",
+	}
+
+	h := a.NewHTTP()
+	errCh := make(chan error, 1)
+	go func() { errCh <- h.StartTLS() }()
+	defer h.echo.TLSServer.Close()
+	time.Sleep(time.Millisecond * 500)
+	select {
+	case err := <-errCh:
+		t.Fatalf("StartTLS with EAB failed: %v", err)
+	default:
+	}
+
+	// The ACME account (with EAB) is registered on the first certificate request.
+	cert, err := h.echo.TLSServer.TLSConfig.GetCertificate(&tls.ClientHelloInfo{ServerName: "armor.test"})
+	if err != nil || cert == nil {
+		t.Fatalf("expected an ACME certificate for armor.test, got cert=%v err=%v", cert, err)
+	}
+}
@@ -0,0 +1,63 @@
+package armor
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/labstack/gommon/log"
+)
+
+// TestStartTLSInternalCAFallback makes sure the internal CA is reachable for
+// an intranet host even when ACME is also enabled for public hosts, i.e.
+// that GetCertificate tries both in sequence instead of treating them as
+// mutually exclusive branches.
+func TestStartTLSInternalCAFallback(t *testing.T) {
+	caDir, err := ioutil.TempDir("", "armor-internal-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(caDir)
+
+	a := &Armor{
+		Logger:  log.New("test"),
+		Address: ":0",
+		Hosts: map[string]*Host{
+			"intranet.local": {},
+		},
+		TLS: &TLS{
+			Address: ":0",
+			Auto:    true, // ACME enabled, but never reachable for "intranet.local" here
+			// Point at an unreachable local address instead of the real
+			// Let's Encrypt directory, so the ACME attempt fails fast
+			// instead of hitting the network, and use the same temp dir
+			// as the internal CA instead of polluting ~/.armor/cache.
+			DirectoryURL: "http://127.0.0.1:0",
+			CacheDir:     caDir,
+			InternalCA: &InternalCA{
+				Enable:   true,
+				CacheDir: caDir,
+			},
+		},
+	}
+
+	h := a.NewHTTP()
+	go h.StartTLS()
+	defer h.echo.TLSServer.Close()
+	time.Sleep(time.Millisecond * 500)
+
+	getCertificate := h.echo.TLSServer.TLSConfig.GetCertificate
+	if getCertificate == nil {
+		t.Fatal("expected StartTLS to have installed GetCertificate by now")
+	}
+
+	cert, err := getCertificate(&tls.ClientHelloInfo{ServerName: "intranet.local"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert == nil {
+		t.Fatal("expected the internal CA to issue a certificate for intranet.local despite TLS.Auto being enabled")
+	}
+}
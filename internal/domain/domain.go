@@ -0,0 +1,162 @@
+// Package domain resolves an incoming request's host to whatever is
+// configured for it, supporting wildcard subdomains, IDNA-normalised names
+// and a default fallback host. It replaces a flat map lookup, which cannot
+// express any of those.
+//
+// Map is wired into HTTP.StartTLS's GetCertificate and AutoTLSManager
+// HostPolicy so ACME and the internal CA already issue for wildcard hosts.
+// This snapshot of the tree has no request-dispatch/routing pipeline for
+// Map.Resolve's Value (the per-host plugin chain) to be consulted from, so
+// wiring per-path plugin resolution on wildcard hosts end-to-end is left
+// for whoever adds that pipeline; Resolve already returns everything such
+// a caller would need.
+package domain
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/net/idna"
+)
+
+type (
+	// Host is what a name in the Map resolves to. Value carries whatever the
+	// caller needs for routing (e.g. the armor package's own *Host), so this
+	// package stays independent of it.
+	Host struct {
+		Name  string
+		Value interface{}
+	}
+
+	// Map resolves host names to a Host, with an atomic swap so a reload
+	// never observes a half-updated table.
+	Map struct {
+		data atomic.Value // *mapData
+	}
+
+	mapData struct {
+		exact    map[string]*Host
+		wildcard map[string]*Host // keyed by the suffix after "*."
+		def      *Host
+	}
+)
+
+// ErrNotFound is returned by Resolve when host matches no entry and no
+// default host is configured.
+var ErrNotFound = errors.New("domain: host not found")
+
+// NewMap builds a Map from hosts, keyed by the configured name (an exact
+// name or a "*.example.com" wildcard), falling back to def when nothing
+// matches.
+func NewMap(hosts map[string]*Host, def *Host) (*Map, error) {
+	m := &Map{}
+	if err := m.Swap(hosts, def); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Swap atomically replaces the resolver's backing data.
+func (m *Map) Swap(hosts map[string]*Host, def *Host) error {
+	data := &mapData{
+		exact:    make(map[string]*Host),
+		wildcard: make(map[string]*Host),
+		def:      def,
+	}
+	for name, host := range hosts {
+		norm, err := normalize(name)
+		if err != nil {
+			return err
+		}
+		if suffix := strings.TrimPrefix(norm, "*."); suffix != norm {
+			data.wildcard[suffix] = host
+		} else {
+			data.exact[norm] = host
+		}
+	}
+	m.data.Store(data)
+	return nil
+}
+
+// Resolve returns the Host configured for host: an exact match, otherwise
+// the most specific matching wildcard, otherwise the default host.
+func (m *Map) Resolve(host string) (*Host, error) {
+	data, norm, err := m.lookup(host)
+	if err != nil {
+		return nil, err
+	}
+
+	if h, ok := data.exact[norm]; ok {
+		return h, nil
+	}
+	if h, ok := data.matchWildcard(norm); ok {
+		return h, nil
+	}
+	if data.def != nil {
+		return data.def, nil
+	}
+	return nil, ErrNotFound
+}
+
+// Known reports whether host matches a configured exact or wildcard entry,
+// ignoring the default host. Callers deciding whether to issue a
+// certificate for host (e.g. an ACME HostPolicy) should use this instead of
+// Resolve, since a catch-all default must never make every hostname look
+// legitimate.
+func (m *Map) Known(host string) bool {
+	data, norm, err := m.lookup(host)
+	if err != nil {
+		return false
+	}
+	if _, ok := data.exact[norm]; ok {
+		return true
+	}
+	_, ok := data.matchWildcard(norm)
+	return ok
+}
+
+func (m *Map) lookup(host string) (*mapData, string, error) {
+	data, _ := m.data.Load().(*mapData)
+	if data == nil {
+		return nil, "", ErrNotFound
+	}
+	norm, err := normalize(host)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, norm, nil
+}
+
+func (d *mapData) matchWildcard(norm string) (*Host, bool) {
+	labels := strings.Split(norm, ".")
+	for i := 1; i < len(labels); i++ {
+		if h, ok := d.wildcard[strings.Join(labels[i:], ".")]; ok {
+			return h, true
+		}
+	}
+	return nil, false
+}
+
+// normalize strips any port, lower-cases host and applies IDNA ToASCII, so
+// lookups are case-insensitive and Unicode names match their punycode
+// configuration.
+func normalize(host string) (string, error) {
+	if i := strings.IndexByte(host, ':'); i != -1 {
+		host = host[:i]
+	}
+	host = strings.ToLower(host)
+
+	label := strings.TrimPrefix(host, "*.")
+	if label == "" {
+		return host, nil
+	}
+	ascii, err := idna.Lookup.ToASCII(label)
+	if err != nil {
+		return "", err
+	}
+	if label != host {
+		return "*." + ascii, nil
+	}
+	return ascii, nil
+}
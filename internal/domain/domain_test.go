@@ -0,0 +1,48 @@
+package domain
+
+import "testing"
+
+func TestResolve(t *testing.T) {
+	def := &Host{Name: "default"}
+	hosts := map[string]*Host{
+		"Example.com":   {Name: "example.com"},
+		"*.example.com": {Name: "wildcard.example.com"},
+	}
+	m, err := NewMap(hosts, def)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		host string
+		want string
+	}{
+		{"example.com", "example.com"},
+		{"EXAMPLE.COM:443", "example.com"},
+		{"foo.example.com", "wildcard.example.com"},
+		{"bar.foo.example.com", "wildcard.example.com"},
+		{"unknown.test", "default"},
+	}
+	for _, c := range cases {
+		h, err := m.Resolve(c.host)
+		if err != nil {
+			t.Fatalf("Resolve(%q): %v", c.host, err)
+		}
+		if h.Name != c.want {
+			t.Errorf("Resolve(%q) = %q, want %q", c.host, h.Name, c.want)
+		}
+	}
+}
+
+func TestKnownIgnoresDefault(t *testing.T) {
+	m, err := NewMap(map[string]*Host{"example.com": {Name: "example.com"}}, &Host{Name: "default"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Known("example.com") {
+		t.Error("expected example.com to be known")
+	}
+	if m.Known("unknown.test") {
+		t.Error("expected unknown.test not to be known despite a default host")
+	}
+}
@@ -0,0 +1,97 @@
+package plugin
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// HeaderClientCertSubject carries the verified peer certificate's
+	// subject upstream.
+	HeaderClientCertSubject = "X-Client-Cert-Subject"
+	// HeaderClientCertSPKISHA256 carries the base64 SHA-256 digest of the
+	// verified peer certificate's public key upstream.
+	HeaderClientCertSPKISHA256 = "X-Client-Cert-SPKI-SHA256"
+)
+
+type (
+	// MTLS enforces mutual TLS on the routes it is attached to. The
+	// server-wide handshake (ClientAuth mode and the pool of trusted client
+	// CAs) is configured once via TLS.ClientAuthType/TLS.ClientCAFile*; MTLS
+	// only enforces, per route, that a verified peer certificate is present
+	// and optionally matches an allow expression, then forwards identifying
+	// headers upstream.
+	MTLS struct {
+		Base
+		// Allow is an Expression evaluated with "cert:cn", "cert:ou",
+		// "cert:serial" and "cert:san" tags available alongside the usual
+		// request tags. The peer certificate is rejected unless the
+		// expression evaluates to true.
+		Allow string `yaml:"allow"`
+
+		allow *Expression
+	}
+)
+
+func (m *MTLS) Initialize() {
+	var allow *Expression
+	if m.Allow != "" {
+		allow = NewExpression(m.Allow)
+	}
+	m.mutex.Lock()
+	m.allow = allow
+	m.mutex.Unlock()
+}
+
+// Update swaps m's configuration in place for a freshly-decoded plugin of
+// the same name. m.Base is left alone (not overwritten wholesale) so the
+// mutex guarding m.allow against concurrent Process calls keeps its
+// identity across the reload.
+func (m *MTLS) Update(p Plugin) {
+	old := p.(*MTLS)
+	m.Skip = old.Skip
+	m.Middleware = old.Middleware
+	m.Allow = old.Allow
+	m.Initialize()
+}
+
+func (m *MTLS) Process(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tlsState := c.Request().TLS
+		if tlsState == nil || len(tlsState.VerifiedChains) == 0 {
+			return echo.NewHTTPError(http.StatusUnauthorized, "client certificate required")
+		}
+
+		m.mutex.RLock()
+		allow := m.allow
+		m.mutex.RUnlock()
+
+		if allow != nil {
+			result, err := allow.Evaluate(c)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+			}
+			if allowed, ok := result.(bool); !ok || !allowed {
+				return echo.NewHTTPError(http.StatusForbidden, "client certificate not allowed")
+			}
+		}
+
+		peer := tlsState.VerifiedChains[0][0]
+		c.Request().Header.Set(HeaderClientCertSubject, peer.Subject.String())
+		c.Request().Header.Set(HeaderClientCertSPKISHA256, spkiFingerprint(peer))
+
+		return next(c)
+	}
+}
+
+// spkiFingerprint returns the base64-encoded SHA-256 digest of cert's
+// subject public key info, the same identifier pinned by HPKP-style
+// key-pinning checks.
+func spkiFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
@@ -67,6 +67,7 @@ const (
 	PluginProxy               = "proxy"
 	PluginStatic              = "static"
 	PluginFile                = "file"
+	PluginMTLS                = "mtls"
 )
 
 var (
@@ -111,6 +112,8 @@ var (
 			p = &Static{Base: base}
 		case PluginFile:
 			p = &File{Base: base}
+		case PluginMTLS:
+			p = &MTLS{Base: base}
 		}
 		return
 	}
@@ -237,6 +240,30 @@ func mapTag(b *bytes.Buffer, c echo.Context, t string) {
 			b.WriteString(c.QueryParam(t[6:]))
 		case strings.HasPrefix(t, "form:"):
 			b.WriteString(c.FormValue(t[5:]))
+		case strings.HasPrefix(t, "cert:"):
+			b.WriteString(certTag(c, t[5:]))
 		}
 	}
 }
+
+// certTag resolves a "cert:*" expression tag against the first verified
+// chain of the request's peer certificate. It returns the empty string when
+// the request did not go through mutual TLS, so allowlist expressions fail
+// closed rather than panicking.
+func certTag(c echo.Context, tag string) string {
+	if c.Request().TLS == nil || len(c.Request().TLS.VerifiedChains) == 0 {
+		return ""
+	}
+	peer := c.Request().TLS.VerifiedChains[0][0]
+	switch tag {
+	case "cn":
+		return peer.Subject.CommonName
+	case "ou":
+		return strings.Join(peer.Subject.OrganizationalUnit, ",")
+	case "serial":
+		return peer.SerialNumber.String()
+	case "san":
+		return strings.Join(peer.DNSNames, ",")
+	}
+	return ""
+}
@@ -0,0 +1,279 @@
+package plugin
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+type (
+	// Proxy forwards requests to one or more upstream targets. Every Proxy
+	// instance configured with identical transport settings shares one
+	// *http.Transport, and the idle-connection pool that comes with it,
+	// instead of dialing its own.
+	Proxy struct {
+		Base
+		Targets []*ProxyTarget `yaml:"targets"`
+
+		Timeout time.Duration `yaml:"timeout"`
+
+		// Backend mTLS and connection tuning. Proxy blocks that share the
+		// same values here share the same pooled transport.
+		TLSCAFile          string        `yaml:"tls_ca_file"`
+		TLSCertFile        string        `yaml:"tls_cert_file"`
+		TLSKeyFile         string        `yaml:"tls_key_file"`
+		ServerName         string        `yaml:"server_name"`
+		InsecureSkipVerify bool          `yaml:"insecure_skip_verify"`
+		DisableHTTP2       bool          `yaml:"disable_http2"`
+		ProxyURL           string        `yaml:"proxy_url"`
+		KeepAlive          time.Duration `yaml:"keep_alive"`
+		MaxIdleConns       int           `yaml:"max_idle_conns"`
+
+		transportKey string
+		balancer     *proxyBalancer
+	}
+
+	// ProxyTarget is a single reverse-proxy upstream.
+	ProxyTarget struct {
+		Name string `yaml:"name"`
+		URL  string `yaml:"url"`
+
+		url *url.URL
+	}
+
+	// proxyBalancer round-robins across the configured targets.
+	proxyBalancer struct {
+		mutex   sync.Mutex
+		targets []*ProxyTarget
+		i       int
+	}
+)
+
+func (p *Proxy) Initialize() {
+	targets := make([]*ProxyTarget, len(p.Targets))
+	for i, t := range p.Targets {
+		u, err := url.Parse(t.URL)
+		if err != nil {
+			panic(err)
+		}
+		targets[i] = &ProxyTarget{Name: t.Name, URL: t.URL, url: u}
+	}
+
+	key, err := acquireTransport(p)
+	if err != nil {
+		panic(err)
+	}
+
+	p.mutex.Lock()
+	p.balancer = &proxyBalancer{targets: targets}
+	p.transportKey = key
+	p.mutex.Unlock()
+}
+
+// Update swaps p's configuration in place for a freshly-decoded plugin of
+// the same name. The fields Process reads concurrently from in-flight
+// requests (balancer, transportKey) are only ever replaced while holding
+// p.mutex; p.Base itself is left alone so that mutex, along with Echo/Logger
+// wiring set up once at Decode time, stays the one everyone is already
+// synchronizing on.
+func (p *Proxy) Update(plug Plugin) {
+	other := plug.(*Proxy)
+
+	p.Skip = other.Skip
+	p.Middleware = other.Middleware
+	p.Targets = other.Targets
+	p.Timeout = other.Timeout
+	p.TLSCAFile = other.TLSCAFile
+	p.TLSCertFile = other.TLSCertFile
+	p.TLSKeyFile = other.TLSKeyFile
+	p.ServerName = other.ServerName
+	p.InsecureSkipVerify = other.InsecureSkipVerify
+	p.DisableHTTP2 = other.DisableHTTP2
+	p.ProxyURL = other.ProxyURL
+	p.KeepAlive = other.KeepAlive
+	p.MaxIdleConns = other.MaxIdleConns
+
+	p.mutex.RLock()
+	oldKey := p.transportKey
+	p.mutex.RUnlock()
+
+	// Acquire the (possibly identical, pool-deduplicated) new transport
+	// before releasing the old reference, so a transport that's still in
+	// use is never evicted out from under a concurrent Process call.
+	p.Initialize()
+	releaseTransport(oldKey)
+}
+
+func (p *Proxy) Process(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		p.mutex.RLock()
+		balancer := p.balancer
+		transportKey := p.transportKey
+		p.mutex.RUnlock()
+
+		target := balancer.next()
+		if target == nil {
+			return echo.ErrServiceUnavailable
+		}
+
+		transport := transportFor(transportKey)
+		if transport == nil {
+			// Never fall back to http.DefaultTransport: that would silently
+			// drop the configured backend mTLS client cert/CA pinning.
+			return echo.NewHTTPError(http.StatusBadGateway, "proxy: transport unavailable")
+		}
+
+		rp := httputil.NewSingleHostReverseProxy(target.url)
+		rp.Transport = transport
+		rp.ServeHTTP(c.Response(), c.Request())
+		return nil
+	}
+}
+
+func (b *proxyBalancer) next() *ProxyTarget {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if len(b.targets) == 0 {
+		return nil
+	}
+	t := b.targets[b.i%len(b.targets)]
+	b.i++
+	return t
+}
+
+type pooledTransport struct {
+	transport *http.Transport
+	refs      int
+}
+
+var (
+	transportPoolMutex sync.Mutex
+	transportPool      = map[string]*pooledTransport{}
+)
+
+// acquireTransport returns the pool key for a transport matching p's
+// settings, building and caching one if this is the first Proxy instance to
+// ask for it, and bumping its reference count otherwise.
+func acquireTransport(p *Proxy) (string, error) {
+	key := poolKey(p)
+
+	transportPoolMutex.Lock()
+	defer transportPoolMutex.Unlock()
+
+	if pooled, ok := transportPool[key]; ok {
+		pooled.refs++
+		return key, nil
+	}
+
+	transport, err := newTransport(p)
+	if err != nil {
+		return "", err
+	}
+	transportPool[key] = &pooledTransport{transport: transport, refs: 1}
+	return key, nil
+}
+
+// releaseTransport drops a reference to the pooled transport identified by
+// key, closing and evicting it once no Proxy instance still needs it.
+func releaseTransport(key string) {
+	if key == "" {
+		return
+	}
+
+	transportPoolMutex.Lock()
+	defer transportPoolMutex.Unlock()
+
+	pooled, ok := transportPool[key]
+	if !ok {
+		return
+	}
+	pooled.refs--
+	if pooled.refs <= 0 {
+		pooled.transport.CloseIdleConnections()
+		delete(transportPool, key)
+	}
+}
+
+func transportFor(key string) *http.Transport {
+	transportPoolMutex.Lock()
+	defer transportPoolMutex.Unlock()
+	pooled, ok := transportPool[key]
+	if !ok {
+		return nil
+	}
+	return pooled.transport
+}
+
+// poolKey hashes the fields that determine whether two proxy configurations
+// can safely share one *http.Transport.
+func poolKey(p *Proxy) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s|%s|%s|%s|%s|%t|%s|%t|%s|%d",
+		p.Timeout, p.TLSCAFile, p.TLSCertFile, p.TLSKeyFile, p.ServerName,
+		p.InsecureSkipVerify, p.ProxyURL, !p.DisableHTTP2, p.KeepAlive, p.MaxIdleConns)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func newTransport(p *Proxy) (*http.Transport, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: p.InsecureSkipVerify,
+		ServerName:         p.ServerName,
+	}
+
+	if p.TLSCAFile != "" {
+		pem, err := ioutil.ReadFile(p.TLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("proxy: invalid tls_ca_file %s", p.TLSCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if p.TLSCertFile != "" && p.TLSKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(p.TLSCertFile, p.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   p.Timeout,
+		KeepAlive: p.KeepAlive,
+	}
+	transport := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         dialer.DialContext,
+		TLSClientConfig:     tlsConfig,
+		MaxIdleConns:        p.MaxIdleConns,
+		MaxIdleConnsPerHost: p.MaxIdleConns,
+	}
+	if p.DisableHTTP2 {
+		// Prevents the transport from silently upgrading to HTTP/2 when the
+		// backend advertises it via ALPN.
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+	if p.ProxyURL != "" {
+		u, err := url.Parse(p.ProxyURL)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(u)
+	}
+
+	return transport, nil
+}
@@ -0,0 +1,108 @@
+package plugin
+
+import (
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func newTestProxy(targetURL string) *Proxy {
+	return &Proxy{
+		Base:    Base{mutex: new(sync.RWMutex)},
+		Targets: []*ProxyTarget{{Name: "t1", URL: targetURL}},
+	}
+}
+
+// TestProxyTransportPoolRefcounting makes sure two Proxy instances with
+// identical transport settings share one pooled *http.Transport, and that
+// the pool entry is only evicted once both have released it.
+func TestProxyTransportPoolRefcounting(t *testing.T) {
+	p1 := newTestProxy("http://127.0.0.1:1")
+	p1.Initialize()
+	defer releaseTransport(p1.transportKey)
+
+	p2 := newTestProxy("http://127.0.0.1:2")
+	p2.Initialize()
+
+	if p1.transportKey != p2.transportKey {
+		t.Fatal("expected proxies with identical transport settings to share one pool key")
+	}
+
+	releaseTransport(p2.transportKey)
+	if transportFor(p1.transportKey) == nil {
+		t.Fatal("expected the pooled transport to survive while p1 still holds a reference")
+	}
+}
+
+// TestProxyPoolKeyDistinguishesServerName makes sure two otherwise-identical
+// proxies that only differ in ServerName (the SNI override baked into the
+// pooled *http.Transport's tls.Config) never collide on the same pool key
+// and silently share a transport.
+func TestProxyPoolKeyDistinguishesServerName(t *testing.T) {
+	p1 := newTestProxy("http://127.0.0.1:1")
+	p1.ServerName = "a.example.com"
+	p1.Initialize()
+	defer releaseTransport(p1.transportKey)
+
+	p2 := newTestProxy("http://127.0.0.1:1")
+	p2.ServerName = "b.example.com"
+	p2.Initialize()
+	defer releaseTransport(p2.transportKey)
+
+	if p1.transportKey == p2.transportKey {
+		t.Fatal("expected proxies with different ServerName to get distinct pool keys")
+	}
+}
+
+// TestProxyProcessFailsClosedOnMissingTransport makes sure Process returns
+// an error rather than silently falling back to http.DefaultTransport (and
+// so bypassing configured backend mTLS) when the pool entry is gone.
+func TestProxyProcessFailsClosedOnMissingTransport(t *testing.T) {
+	p := newTestProxy("http://127.0.0.1:1")
+	p.Initialize()
+	releaseTransport(p.transportKey)
+
+	e := echo.New()
+	req := httptest.NewRequest("GET", "/", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	err := p.Process(func(c echo.Context) error { return nil })(c)
+	if err == nil {
+		t.Fatal("expected Process to error out once its pooled transport is gone")
+	}
+}
+
+// TestProxyUpdateConcurrentWithProcess exercises Update and Process
+// concurrently so the race detector can catch any unguarded access to
+// balancer/transportKey.
+func TestProxyUpdateConcurrentWithProcess(t *testing.T) {
+	p := newTestProxy("http://127.0.0.1:1")
+	p.Initialize()
+	defer releaseTransport(p.transportKey)
+
+	e := echo.New()
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			req := httptest.NewRequest("GET", "/", nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			p.Process(func(c echo.Context) error { return nil })(c)
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			p.Update(newTestProxy("http://127.0.0.1:1"))
+		}
+	}()
+
+	wg.Wait()
+}